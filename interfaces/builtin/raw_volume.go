@@ -21,6 +21,7 @@ package builtin
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -50,10 +51,15 @@ const rawVolumeBaseDeclarationSlots = `
 
 // Only allow disk device partitions; not loop, ram, CDROM, generic SCSI,
 // network, tape, raid, etc devices
-const rawVolumeConnectedPlugAppArmorPath = `
+const rawVolumeConnectedPlugAppArmorPathFmt = `
 # Description: can access disk partition read/write
 %s rw,
+`
 
+// rawVolumeConnectedPlugAppArmorStatic is added once per connection,
+// regardless of how many device-path rules (see
+// rawVolumeConnectedPlugAppArmorPathFmt above) were emitted for it.
+const rawVolumeConnectedPlugAppArmorStatic = `
 # needed for write access
 capability sys_admin,
 
@@ -117,6 +123,74 @@ const vdPat = `vd[a-z]([1-9]|[1-5][0-9]|6[0-3])`
 
 var rawVolumePartitionPattern = regexp.MustCompile(fmt.Sprintf("^/dev/(%s|%s|%s|%s|%s|%s)$", hdPat, sdPat, i2oPat, mmcPat, nvmePat, vdPat))
 
+// Kernel-assigned device node names are unstable across reboots on systems
+// with multiple controllers or hotpluggable storage, so also accept the
+// persistent-name symlinks udev maintains under /dev/disk/by-*. UUIDs and
+// partition UUIDs are hex digits grouped with dashes; labels and ids are
+// restricted to the characters udev itself uses when generating them, which
+// rules out shell wildcards but (since "." is a valid label character) not
+// a bare "." or ".." component, which rawVolumePathIsValid rejects itself.
+const hexDashPat = `[0-9A-Fa-f]+(-[0-9A-Fa-f]+)*`
+const labelPat = `[A-Za-z0-9_.:-]+`
+
+var rawVolumeByUUIDPattern = regexp.MustCompile("^/dev/disk/by-uuid/(" + hexDashPat + ")$")
+var rawVolumeByLabelPattern = regexp.MustCompile("^/dev/disk/by-label/(" + labelPat + ")$")
+var rawVolumeByPartUUIDPattern = regexp.MustCompile("^/dev/disk/by-partuuid/(" + hexDashPat + ")$")
+var rawVolumeByIDPattern = regexp.MustCompile("^/dev/disk/by-id/(" + labelPat + ")$")
+
+// rawVolumePersistentNamePatterns lists the persistent-name patterns in
+// addition to rawVolumePartitionPattern that a path attribute may match.
+var rawVolumePersistentNamePatterns = []*regexp.Regexp{
+	rawVolumeByUUIDPattern,
+	rawVolumeByLabelPattern,
+	rawVolumeByPartUUIDPattern,
+	rawVolumeByIDPattern,
+}
+
+func rawVolumePathIsValid(path string) bool {
+	if rawVolumePartitionPattern.MatchString(path) {
+		return true
+	}
+	for _, pattern := range rawVolumePersistentNamePatterns {
+		if pattern.MatchString(path) {
+			// labelPat's character class includes "." so it also
+			// matches a bare "." or ".." component; reject those
+			// explicitly rather than relying on callers to Clean
+			// the path first
+			name := filepath.Base(path)
+			if name == "." || name == ".." {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// rawVolumeUdevCondition returns the udev rule condition that matches the
+// device referred to by path. by-uuid, by-label and by-partuuid paths are
+// keyed off the udev property they were derived from, and by-id paths off
+// the by-id symlink itself, instead of the (potentially unstable) kernel
+// device name.
+func rawVolumeUdevCondition(path string) string {
+	switch {
+	case rawVolumeByUUIDPattern.MatchString(path):
+		return fmt.Sprintf(`ENV{ID_FS_UUID}=="%s"`, filepath.Base(path))
+	case rawVolumeByLabelPattern.MatchString(path):
+		return fmt.Sprintf(`ENV{ID_FS_LABEL}=="%s"`, filepath.Base(path))
+	case rawVolumeByPartUUIDPattern.MatchString(path):
+		return fmt.Sprintf(`ENV{ID_PART_ENTRY_UUID}=="%s"`, filepath.Base(path))
+	case rawVolumeByIDPattern.MatchString(path):
+		// the by-id link name itself (eg. "ata-SAMSUNG_..." or
+		// "wwn-0x...") is not equal to any single udev property, so match
+		// the symlink udev creates rather than guess which property it
+		// was derived from
+		return fmt.Sprintf(`SYMLINK=="disk/by-id/%s"`, filepath.Base(path))
+	default:
+		return fmt.Sprintf(`KERNEL=="%s"`, strings.TrimPrefix(path, "/dev/"))
+	}
+}
+
 // Check validity of the defined slot
 func (iface *rawVolumeInterface) BeforePrepareSlot(slot *snap.SlotInfo) error {
 	path, ok := slot.Attrs["path"].(string)
@@ -124,13 +198,44 @@ func (iface *rawVolumeInterface) BeforePrepareSlot(slot *snap.SlotInfo) error {
 		return fmt.Errorf("%s slot must have a path attribute", iface.Name())
 	}
 	path = filepath.Clean(path)
-	if !rawVolumePartitionPattern.MatchString(path) {
+	if !rawVolumePathIsValid(path) {
 		return fmt.Errorf("%s path attribute must be a valid device node", iface.Name())
 	}
 
 	return nil
 }
 
+// osReadlink is a variable so tests can mock it without needing an actual
+// symlink on disk.
+var osReadlink = os.Readlink
+
+// rawVolumeDevicePaths returns the paths that need an apparmor "rw" rule
+// for cleanedPath: the path itself and, if it is a symlink (as the
+// persistent-name paths under /dev/disk/by-* are), its resolved target, so
+// that mediation works regardless of which of the two paths the app
+// actually opens.
+//
+// If cleanedPath cannot be resolved (eg. the device is not present at
+// profile-generation time, which can happen during seeding or with
+// hotpluggable storage), only cleanedPath itself is returned.
+func rawVolumeDevicePaths(cleanedPath string) []string {
+	paths := []string{cleanedPath}
+
+	target, err := osReadlink(cleanedPath)
+	if err != nil {
+		return paths
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(cleanedPath), target)
+	}
+	target = filepath.Clean(target)
+	if target != cleanedPath {
+		paths = append(paths, target)
+	}
+
+	return paths
+}
+
 func (iface *rawVolumeInterface) AppArmorConnectedPlug(spec *apparmor.Specification, plug *interfaces.ConnectedPlug, slot *interfaces.ConnectedSlot) error {
 	var path string
 	if err := slot.Attr("path", &path); err != nil {
@@ -138,7 +243,10 @@ func (iface *rawVolumeInterface) AppArmorConnectedPlug(spec *apparmor.Specificat
 	}
 
 	cleanedPath := filepath.Clean(path)
-	spec.AddSnippet(fmt.Sprintf(rawVolumeConnectedPlugAppArmorPath, cleanedPath))
+	for _, p := range rawVolumeDevicePaths(cleanedPath) {
+		spec.AddSnippet(fmt.Sprintf(rawVolumeConnectedPlugAppArmorPathFmt, p))
+	}
+	spec.AddSnippet(rawVolumeConnectedPlugAppArmorStatic)
 
 	return nil
 }
@@ -150,7 +258,7 @@ func (iface *rawVolumeInterface) UDevConnectedPlug(spec *udev.Specification, plu
 	}
 
 	cleanedPath := filepath.Clean(path)
-	spec.TagDevice(fmt.Sprintf(`KERNEL=="%s"`, strings.TrimPrefix(cleanedPath, "/dev/")))
+	spec.TagDevice(rawVolumeUdevCondition(cleanedPath))
 
 	return nil
 }
@@ -0,0 +1,119 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package builtin
+
+import (
+	"errors"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type rawVolumeSuite struct{}
+
+var _ = Suite(&rawVolumeSuite{})
+
+func (s *rawVolumeSuite) TestRawVolumePathIsValid(c *C) {
+	valid := []string{
+		"/dev/sda3",
+		"/dev/nvme0n1p2",
+		"/dev/disk/by-uuid/1234-ABCD",
+		"/dev/disk/by-uuid/c0ffee12-3456-7890-abcd-ef0123456789",
+		"/dev/disk/by-label/my-data_disk.1",
+		"/dev/disk/by-partuuid/abcdef01-2345-6789-abcd-ef0123456789",
+		"/dev/disk/by-id/ata-SAMSUNG_MZVLB512HAJQ-000L7_S3W8NX0N123456",
+		"/dev/disk/by-id/wwn-0x5002538e40a12345",
+	}
+	for _, path := range valid {
+		c.Check(rawVolumePathIsValid(path), Equals, true, Commentf("path %q", path))
+	}
+
+	invalid := []string{
+		"/dev/disk/by-uuid/../../../etc/passwd",
+		"/dev/disk/by-label/../../sda",
+		"/dev/disk/by-label/..",
+		"/dev/disk/by-label/.",
+		"/dev/disk/by-id/..",
+		"/dev/disk/by-id/*",
+		"/dev/disk/by-id/foo/../../bar",
+		"/dev/disk/by-uuid/",
+		"/dev/disk/by-uuid/not a uuid",
+		"/dev/loop0",
+		"/dev/sr0",
+	}
+	for _, path := range invalid {
+		c.Check(rawVolumePathIsValid(path), Equals, false, Commentf("path %q", path))
+	}
+}
+
+func (s *rawVolumeSuite) TestRawVolumeUdevCondition(c *C) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/dev/sda3", `KERNEL=="sda3"`},
+		{"/dev/disk/by-uuid/1234-ABCD", `ENV{ID_FS_UUID}=="1234-ABCD"`},
+		{"/dev/disk/by-label/my-data", `ENV{ID_FS_LABEL}=="my-data"`},
+		{"/dev/disk/by-partuuid/abcdef01-2345", `ENV{ID_PART_ENTRY_UUID}=="abcdef01-2345"`},
+		{"/dev/disk/by-id/ata-SAMSUNG_XYZ", `SYMLINK=="disk/by-id/ata-SAMSUNG_XYZ"`},
+	}
+	for _, tc := range tests {
+		c.Check(rawVolumeUdevCondition(tc.path), Equals, tc.want, Commentf("path %q", tc.path))
+	}
+}
+
+func (s *rawVolumeSuite) mockOsReadlink(c *C, f func(string) (string, error)) {
+	old := osReadlink
+	osReadlink = f
+	c.AddCleanup(func() { osReadlink = old })
+}
+
+func (s *rawVolumeSuite) TestRawVolumeDevicePathsNoSymlink(c *C) {
+	s.mockOsReadlink(c, func(name string) (string, error) {
+		return "", errors.New("not a symlink")
+	})
+
+	got := rawVolumeDevicePaths("/dev/sda3")
+	c.Check(got, DeepEquals, []string{"/dev/sda3"})
+}
+
+func (s *rawVolumeSuite) TestRawVolumeDevicePathsResolvesRelativeSymlink(c *C) {
+	s.mockOsReadlink(c, func(name string) (string, error) {
+		c.Assert(name, Equals, "/dev/disk/by-uuid/1234-ABCD")
+		return "../../sda3", nil
+	})
+
+	got := rawVolumeDevicePaths("/dev/disk/by-uuid/1234-ABCD")
+	c.Check(got, DeepEquals, []string{"/dev/disk/by-uuid/1234-ABCD", "/dev/sda3"})
+}
+
+func (s *rawVolumeSuite) TestRawVolumeDevicePathsMissingDeviceFallsBackToSinglePath(c *C) {
+	// at profile-generation time the device may not be present yet (eg.
+	// during seeding, or before a hotpluggable device is plugged in); in
+	// that case os.Readlink fails and only the configured path is used
+	s.mockOsReadlink(c, func(name string) (string, error) {
+		return "", errors.New("no such file or directory")
+	})
+
+	got := rawVolumeDevicePaths("/dev/disk/by-uuid/1234-ABCD")
+	c.Check(got, DeepEquals, []string{"/dev/disk/by-uuid/1234-ABCD"})
+}
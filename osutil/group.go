@@ -28,15 +28,38 @@ import (
 )
 
 // FindUid returns the identifier of the given UNIX user name. It will
-// automatically fallback to use "getent" if needed.
+// automatically fallback to use "getent" if needed. Results are served
+// from the package's default UserGroupResolver cache.
 func FindUid(username string) (uint64, error) {
-	return findUid(username)
+	return defaultUserGroupResolver.LookupUid(username)
 }
 
 // FindGid returns the identifier of the given UNIX group name. It will
-// automatically fallback to use "getent" if needed.
+// automatically fallback to use "getent" if needed. Results are served
+// from the package's default UserGroupResolver cache.
 func FindGid(groupname string) (uint64, error) {
-	return findGid(groupname)
+	return defaultUserGroupResolver.LookupGid(groupname)
+}
+
+// FindUsername returns the UNIX user name of the given numeric uid. It will
+// automatically fallback to use "getent" if needed. Results are served
+// from the package's default UserGroupResolver cache.
+func FindUsername(uid uint64) (string, error) {
+	return defaultUserGroupResolver.LookupUsername(uid)
+}
+
+// FindGroupname returns the UNIX group name of the given numeric gid. It
+// will automatically fallback to use "getent" if needed. Results are served
+// from the package's default UserGroupResolver cache.
+func FindGroupname(gid uint64) (string, error) {
+	return defaultUserGroupResolver.LookupGroupname(gid)
+}
+
+// UserGroups returns the primary and supplementary group identifiers of the
+// given UNIX user name. It will automatically fallback to use "getent" if
+// needed.
+func UserGroups(username string) ([]uint64, error) {
+	return userGroupsWithGetentFallback(username)
 }
 
 // getent returns the identifier of the given UNIX user or group name as
@@ -76,6 +99,44 @@ func getent(database, name string) (uint64, error) {
 	return strconv.ParseUint(string(parts[2]), 10, 64)
 }
 
+// getentName returns the name of the given UNIX user or group id as
+// determined by the specified database
+func getentName(database string, id uint64) (string, error) {
+	if database != "passwd" && database != "group" {
+		return "", fmt.Errorf(`unsupported getent database %q`, database)
+	}
+
+	idStr := strconv.FormatUint(id, 10)
+	cmdStr := []string{
+		"getent",
+		database,
+		idStr,
+	}
+	cmd := exec.Command(cmdStr[0], cmdStr[1:]...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		// according to getent(1) the exit value of "2" means:
+		// "One or more supplied key could not be found in the
+		// database."
+		exitCode, _ := ExitCode(err)
+		if exitCode == 2 {
+			if database == "passwd" {
+				return "", user.UnknownUserIdError(id)
+			}
+			return "", user.UnknownGroupIdError(strconv.FormatUint(id, 10))
+		}
+		return "", fmt.Errorf("getent failed with: %v", OutputErr(output, err))
+	}
+
+	// passwd has 7 entries and group 4. In both cases, parts[0] is the name
+	parts := bytes.Split(output, []byte(":"))
+	if len(parts) < 4 {
+		return "", fmt.Errorf("malformed entry: %q", output)
+	}
+
+	return string(parts[0]), nil
+}
+
 var findUidNoGetentFallback = func(username string) (uint64, error) {
 	myuser, err := user.Lookup(username)
 	if err != nil {
@@ -136,6 +197,28 @@ var findGidNoGetentFallback = func(groupname string) (uint64, error) {
 	return strconv.ParseUint(group.Gid, 10, 64)
 }
 
+var findUsernameNoGetentFallback = func(uid uint64) (string, error) {
+	myuser, err := user.LookupId(strconv.FormatUint(uid, 10))
+	if err != nil {
+		// see the comment in findUidNoGetentFallback above for why every
+		// error is treated as "unknown", regardless of its actual cause
+		return "", user.UnknownUserIdError(uid)
+	}
+
+	return myuser.Username, nil
+}
+
+var findGroupnameNoGetentFallback = func(gid uint64) (string, error) {
+	group, err := user.LookupGroupId(strconv.FormatUint(gid, 10))
+	if err != nil {
+		// see the comment in findGidNoGetentFallback above for why every
+		// error is treated as "unknown", regardless of its actual cause
+		return "", user.UnknownGroupIdError(strconv.FormatUint(gid, 10))
+	}
+
+	return group.Name, nil
+}
+
 // findUidWithGetentFallback returns the identifier of the given UNIX user name with
 // getent fallback
 func findUidWithGetentFallback(username string) (uint64, error) {
@@ -172,12 +255,189 @@ func findGidWithGetentFallback(groupname string) (uint64, error) {
 	}
 }
 
+// findUsernameWithGetentFallback returns the UNIX user name of the given
+// numeric uid with getent fallback
+func findUsernameWithGetentFallback(uid uint64) (string, error) {
+	// first do the cheap os/user lookup
+	username, err := findUsernameNoGetentFallback(uid)
+	switch err.(type) {
+	case nil:
+		// found it!
+		return username, nil
+	case user.UnknownUserIdError:
+		// uid unknown, let's try getent
+		return getentName("passwd", uid)
+	default:
+		// something weird happened with the lookup, just report it
+		return "", err
+	}
+}
+
+// findGroupnameWithGetentFallback returns the UNIX group name of the given
+// numeric gid with getent fallback
+func findGroupnameWithGetentFallback(gid uint64) (string, error) {
+	// first do the cheap os/user lookup
+	groupname, err := findGroupnameNoGetentFallback(gid)
+	switch err.(type) {
+	case nil:
+		// found it!
+		return groupname, nil
+	case user.UnknownGroupIdError:
+		// gid unknown, let's try getent
+		return getentName("group", gid)
+	default:
+		// something weird happened with the lookup, just report it
+		return "", err
+	}
+}
+
+// getentPasswdEntry and getentGroupDatabase are variables so tests can
+// supply canned "getent passwd <name>"/"getent group" output without
+// depending on the state of the system's actual user/group database.
+var getentPasswdEntry = func(username string) ([]byte, error) {
+	return exec.Command("getent", "passwd", username).CombinedOutput()
+}
+
+var getentGroupDatabase = func() ([]byte, error) {
+	return exec.Command("getent", "group").CombinedOutput()
+}
+
+// getentPrimaryGid returns the primary group identifier of the given UNIX
+// user name by scraping "getent passwd"
+func getentPrimaryGid(username string) (uint64, error) {
+	output, err := getentPasswdEntry(username)
+	if err != nil {
+		exitCode, _ := ExitCode(err)
+		if exitCode == 2 {
+			return 0, user.UnknownUserError(username)
+		}
+		return 0, fmt.Errorf("getent failed with: %v", OutputErr(output, err))
+	}
+
+	// passwd has 7 entries: name:passwd:uid:gid:gecos:home:shell
+	parts := bytes.Split(bytes.TrimSpace(output), []byte(":"))
+	if len(parts) < 7 {
+		return 0, fmt.Errorf("malformed entry: %q", output)
+	}
+
+	return strconv.ParseUint(string(parts[3]), 10, 64)
+}
+
+// getentGroupMemberships returns the identifiers of the groups that list the
+// given UNIX user name in their members field (field 4), by scraping
+// "getent group"
+func getentGroupMemberships(username string) ([]uint64, error) {
+	output, err := getentGroupDatabase()
+	if err != nil {
+		exitCode, _ := ExitCode(err)
+		if exitCode == 2 {
+			// no entries at all in the group database
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getent failed with: %v", OutputErr(output, err))
+	}
+
+	var gids []uint64
+	for _, line := range bytes.Split(bytes.TrimSpace(output), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		parts := bytes.Split(line, []byte(":"))
+		if len(parts) < 4 {
+			continue
+		}
+		for _, member := range bytes.Split(parts[3], []byte(",")) {
+			if string(member) != username {
+				continue
+			}
+			gid, err := strconv.ParseUint(string(parts[2]), 10, 64)
+			if err != nil {
+				continue
+			}
+			gids = append(gids, gid)
+			break
+		}
+	}
+
+	return gids, nil
+}
+
+// getentUserGroups returns the primary and supplementary group identifiers
+// of the given UNIX user name by scraping "getent passwd" and "getent group"
+func getentUserGroups(username string) ([]uint64, error) {
+	primaryGid, err := getentPrimaryGid(username)
+	if err != nil {
+		return nil, err
+	}
+	memberGids, err := getentGroupMemberships(username)
+	if err != nil {
+		return nil, err
+	}
+
+	// the primary group may also be listed in the members field, dedupe it
+	seen := map[uint64]bool{primaryGid: true}
+	gids := []uint64{primaryGid}
+	for _, gid := range memberGids {
+		if seen[gid] {
+			continue
+		}
+		seen[gid] = true
+		gids = append(gids, gid)
+	}
+
+	return gids, nil
+}
+
+var userGroupsNoGetentFallback = func(username string) ([]uint64, error) {
+	myuser, err := user.Lookup(username)
+	if err != nil {
+		return nil, user.UnknownUserError(username)
+	}
+
+	gidStrs, err := myuser.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	gids := make([]uint64, 0, len(gidStrs))
+	for _, gidStr := range gidStrs {
+		gid, err := strconv.ParseUint(gidStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse group id %q for user %q: %v", gidStr, username, err)
+		}
+		gids = append(gids, gid)
+	}
+
+	return gids, nil
+}
+
+// userGroupsWithGetentFallback returns the primary and supplementary group
+// identifiers of the given UNIX user name with getent fallback
+func userGroupsWithGetentFallback(username string) ([]uint64, error) {
+	// first do the cheap os/user lookup
+	gids, err := userGroupsNoGetentFallback(username)
+	if err == nil {
+		return gids, nil
+	}
+	// os/user failed to resolve the user or its groups, fall back to
+	// scraping getent
+	return getentUserGroups(username)
+}
+
 func IsUnknownUser(err error) bool {
-	_, ok := err.(user.UnknownUserError)
-	return ok
+	switch err.(type) {
+	case user.UnknownUserError, user.UnknownUserIdError:
+		return true
+	default:
+		return false
+	}
 }
 
 func IsUnknownGroup(err error) bool {
-	_, ok := err.(user.UnknownGroupError)
-	return ok
+	switch err.(type) {
+	case user.UnknownGroupError, user.UnknownGroupIdError:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+import (
+	"os/exec"
+	"os/user"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type groupSuite struct{}
+
+var _ = Suite(&groupSuite{})
+
+func (s *groupSuite) SetUpTest(c *C) {
+	old := userGroupsNoGetentFallback
+	userGroupsNoGetentFallback = func(username string) ([]uint64, error) {
+		// force userGroupsWithGetentFallback to fall through to the
+		// getent-scraping path for every test in this suite
+		return nil, user.UnknownUserError(username)
+	}
+	c.AddCleanup(func() { userGroupsNoGetentFallback = old })
+}
+
+func (s *groupSuite) mockGetent(c *C, passwdEntry []byte, passwdErr error, groupDatabase []byte, groupErr error) {
+	oldPasswd := getentPasswdEntry
+	getentPasswdEntry = func(username string) ([]byte, error) {
+		return passwdEntry, passwdErr
+	}
+	c.AddCleanup(func() { getentPasswdEntry = oldPasswd })
+
+	oldGroup := getentGroupDatabase
+	getentGroupDatabase = func() ([]byte, error) {
+		return groupDatabase, groupErr
+	}
+	c.AddCleanup(func() { getentGroupDatabase = oldGroup })
+}
+
+func (s *groupSuite) TestUserGroupsDedupesPrimaryGroup(c *C) {
+	// alice's primary group (1000) also lists alice in its members field,
+	// so it is found by the membership scrape as well and must only be
+	// reported once
+	s.mockGetent(c,
+		[]byte("alice:x:1000:1000:Alice:/home/alice:/bin/bash\n"), nil,
+		[]byte("alice:x:1000:alice\nusers:x:100:alice,bob\ndocker:x:999:alice\n"), nil)
+
+	gids, err := UserGroups("alice")
+	c.Assert(err, IsNil)
+	c.Check(gids, DeepEquals, []uint64{1000, 100, 999})
+}
+
+func (s *groupSuite) TestUserGroupsNSSOnlyGroup(c *C) {
+	// bob's only supplementary group comes purely from NSS group
+	// membership scraping, with no primary-group overlap
+	s.mockGetent(c,
+		[]byte("bob:x:1001:1001:Bob:/home/bob:/bin/bash\n"), nil,
+		[]byte("bob:x:1001:\nsudo:x:27:bob\n"), nil)
+
+	gids, err := UserGroups("bob")
+	c.Assert(err, IsNil)
+	c.Check(gids, DeepEquals, []uint64{1001, 27})
+}
+
+func (s *groupSuite) TestUserGroupsNoMemberships(c *C) {
+	s.mockGetent(c,
+		[]byte("carol:x:1002:1002:Carol:/home/carol:/bin/bash\n"), nil,
+		[]byte("carol:x:1002:\n"), nil)
+
+	gids, err := UserGroups("carol")
+	c.Assert(err, IsNil)
+	c.Check(gids, DeepEquals, []uint64{1002})
+}
+
+func (s *groupSuite) TestUserGroupsUnknownUser(c *C) {
+	// "getent passwd" exits with status 2 when the user is not found
+	exitErr := exec.Command("sh", "-c", "exit 2").Run()
+	s.mockGetent(c,
+		nil, exitErr,
+		nil, nil)
+
+	_, err := UserGroups("nobody-such-user")
+	c.Assert(err, NotNil)
+	c.Check(IsUnknownUser(err), Equals, true)
+}
@@ -0,0 +1,261 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultGetentCacheTTL is how long a UserGroupResolver entry is considered
+// fresh, unless overridden via SNAPD_GETENT_CACHE_TTL.
+const defaultGetentCacheTTL = 30 * time.Second
+
+// getentCacheTTL returns the configured cache TTL, accepting either a
+// plain number of seconds or a Go duration string (e.g. "1m") in
+// SNAPD_GETENT_CACHE_TTL.
+func getentCacheTTL() time.Duration {
+	v := os.Getenv("SNAPD_GETENT_CACHE_TTL")
+	if v == "" {
+		return defaultGetentCacheTTL
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d
+	}
+	return defaultGetentCacheTTL
+}
+
+type idCacheEntry struct {
+	id      uint64
+	err     error
+	expires time.Time
+}
+
+type nameCacheEntry struct {
+	name    string
+	err     error
+	expires time.Time
+}
+
+// UserGroupResolver memoises user/group name <-> id lookups (including
+// negative results) for a short TTL. During seeding or refresh of many
+// snaps, interface setup resolves the same handful of system users
+// (snap_daemon, daemon, root, nobody, ...) hundreds of times; each
+// uncached lookup that misses the os/user cgo path forks a "getent"
+// process, which a resolver plus PreloadNames avoids.
+type UserGroupResolver struct {
+	mu        sync.Mutex
+	uidByName map[string]idCacheEntry
+	gidByName map[string]idCacheEntry
+	nameByUid map[uint64]nameCacheEntry
+	nameByGid map[uint64]nameCacheEntry
+}
+
+// NewUserGroupResolver returns a UserGroupResolver caching lookups for
+// SNAPD_GETENT_CACHE_TTL (30s by default). The TTL is re-read from the
+// environment each time an entry is stored rather than fixed at
+// construction time, so that tests (and, in principle, operators) can
+// adjust it for a resolver that already exists, such as the package-level
+// default. Entries already in the cache keep the expiry they were stored
+// with.
+func NewUserGroupResolver() *UserGroupResolver {
+	return &UserGroupResolver{
+		uidByName: make(map[string]idCacheEntry),
+		gidByName: make(map[string]idCacheEntry),
+		nameByUid: make(map[uint64]nameCacheEntry),
+		nameByGid: make(map[uint64]nameCacheEntry),
+	}
+}
+
+// defaultUserGroupResolver backs the package-level FindUid/FindGid helpers.
+var defaultUserGroupResolver = NewUserGroupResolver()
+
+// LookupUid returns the identifier of the given UNIX user name, serving it
+// from the cache when a fresh entry is present.
+func (r *UserGroupResolver) LookupUid(username string) (uint64, error) {
+	r.mu.Lock()
+	if e, ok := r.uidByName[username]; ok && time.Now().Before(e.expires) {
+		r.mu.Unlock()
+		return e.id, e.err
+	}
+	r.mu.Unlock()
+
+	id, err := findUidWithGetentFallback(username)
+	if err == nil || IsUnknownUser(err) {
+		r.mu.Lock()
+		r.uidByName[username] = idCacheEntry{id: id, err: err, expires: time.Now().Add(getentCacheTTL())}
+		r.mu.Unlock()
+	}
+	return id, err
+}
+
+// LookupGid returns the identifier of the given UNIX group name, serving it
+// from the cache when a fresh entry is present.
+func (r *UserGroupResolver) LookupGid(groupname string) (uint64, error) {
+	r.mu.Lock()
+	if e, ok := r.gidByName[groupname]; ok && time.Now().Before(e.expires) {
+		r.mu.Unlock()
+		return e.id, e.err
+	}
+	r.mu.Unlock()
+
+	id, err := findGidWithGetentFallback(groupname)
+	if err == nil || IsUnknownGroup(err) {
+		r.mu.Lock()
+		r.gidByName[groupname] = idCacheEntry{id: id, err: err, expires: time.Now().Add(getentCacheTTL())}
+		r.mu.Unlock()
+	}
+	return id, err
+}
+
+// LookupUsername returns the UNIX user name of the given numeric uid,
+// serving it from the cache when a fresh entry is present.
+func (r *UserGroupResolver) LookupUsername(uid uint64) (string, error) {
+	r.mu.Lock()
+	if e, ok := r.nameByUid[uid]; ok && time.Now().Before(e.expires) {
+		r.mu.Unlock()
+		return e.name, e.err
+	}
+	r.mu.Unlock()
+
+	name, err := findUsernameWithGetentFallback(uid)
+	if err == nil || IsUnknownUser(err) {
+		r.mu.Lock()
+		r.nameByUid[uid] = nameCacheEntry{name: name, err: err, expires: time.Now().Add(getentCacheTTL())}
+		r.mu.Unlock()
+	}
+	return name, err
+}
+
+// LookupGroupname returns the UNIX group name of the given numeric gid,
+// serving it from the cache when a fresh entry is present.
+func (r *UserGroupResolver) LookupGroupname(gid uint64) (string, error) {
+	r.mu.Lock()
+	if e, ok := r.nameByGid[gid]; ok && time.Now().Before(e.expires) {
+		r.mu.Unlock()
+		return e.name, e.err
+	}
+	r.mu.Unlock()
+
+	name, err := findGroupnameWithGetentFallback(gid)
+	if err == nil || IsUnknownGroup(err) {
+		r.mu.Lock()
+		r.nameByGid[gid] = nameCacheEntry{name: name, err: err, expires: time.Now().Add(getentCacheTTL())}
+		r.mu.Unlock()
+	}
+	return name, err
+}
+
+// PreloadNames resolves the given user and group names with a single
+// batched "getent passwd"/"getent group" call each (getent accepts
+// multiple keys and prints every matching entry in one process) and
+// populates the cache with the result, including negative entries for
+// names that were not found.
+func (r *UserGroupResolver) PreloadNames(usernames []string, groupnames []string) {
+	if len(usernames) > 0 {
+		if found, ok := getentBatch("passwd", usernames); ok {
+			expires := time.Now().Add(getentCacheTTL())
+			r.mu.Lock()
+			for _, name := range usernames {
+				entry := idCacheEntry{expires: expires}
+				if id, ok := found[name]; ok {
+					entry.id = id
+				} else {
+					entry.err = user.UnknownUserError(name)
+				}
+				r.uidByName[name] = entry
+			}
+			r.mu.Unlock()
+		}
+		// if the batched getent call itself failed (as opposed to simply
+		// not finding some names), leave the cache untouched: callers will
+		// fall through to an individual, error-reporting lookup instead of
+		// a silently wrong "unknown user" verdict
+	}
+
+	if len(groupnames) > 0 {
+		if found, ok := getentBatch("group", groupnames); ok {
+			expires := time.Now().Add(getentCacheTTL())
+			r.mu.Lock()
+			for _, name := range groupnames {
+				entry := idCacheEntry{expires: expires}
+				if id, ok := found[name]; ok {
+					entry.id = id
+				} else {
+					entry.err = user.UnknownGroupError(name)
+				}
+				r.gidByName[name] = entry
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// PreloadNames preloads the given user and group names into the default
+// resolver backing FindUid/FindGid.
+func PreloadNames(usernames []string, groupnames []string) {
+	defaultUserGroupResolver.PreloadNames(usernames, groupnames)
+}
+
+// getentBatch runs a single "getent <database> <names...>" call and
+// returns the id of every requested name that was found, plus whether the
+// call itself succeeded. Names that getent could not find are simply
+// absent from the result; ok is false only if getent could not be run at
+// all (as opposed to finding some, but not all, of the requested names).
+func getentBatch(database string, names []string) (found map[string]uint64, ok bool) {
+	args := append([]string{database}, names...)
+	cmd := exec.Command("getent", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		// exit code 2 means "one or more supplied key could not be found",
+		// which is expected when batching; getent still prints every
+		// match it did find, so keep parsing the output in that case
+		exitCode, _ := ExitCode(err)
+		if exitCode != 2 {
+			return nil, false
+		}
+	}
+
+	found = make(map[string]uint64, len(names))
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		parts := bytes.Split(line, []byte(":"))
+		if len(parts) < 4 {
+			continue
+		}
+		id, err := strconv.ParseUint(string(parts[2]), 10, 64)
+		if err != nil {
+			continue
+		}
+		found[string(parts[0])] = id
+	}
+
+	return found, true
+}
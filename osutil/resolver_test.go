@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type resolverSuite struct{}
+
+var _ = Suite(&resolverSuite{})
+
+func (s *resolverSuite) setTTL(c *C, ttl string) {
+	old, had := os.LookupEnv("SNAPD_GETENT_CACHE_TTL")
+	os.Setenv("SNAPD_GETENT_CACHE_TTL", ttl)
+	c.AddCleanup(func() {
+		if had {
+			os.Setenv("SNAPD_GETENT_CACHE_TTL", old)
+		} else {
+			os.Unsetenv("SNAPD_GETENT_CACHE_TTL")
+		}
+	})
+}
+
+func (s *resolverSuite) mockFindUidNoGetentFallback(c *C, f func(string) (uint64, error)) {
+	old := findUidNoGetentFallback
+	findUidNoGetentFallback = f
+	c.AddCleanup(func() { findUidNoGetentFallback = old })
+}
+
+func (s *resolverSuite) mockFindGroupnameNoGetentFallback(c *C, f func(uint64) (string, error)) {
+	old := findGroupnameNoGetentFallback
+	findGroupnameNoGetentFallback = f
+	c.AddCleanup(func() { findGroupnameNoGetentFallback = old })
+}
+
+// TestLookupUidInvalidatesNegativeEntryAfterTTL locks down that a user who
+// is unknown at first lookup, but created shortly after, is no longer
+// reported as unknown once the cached negative entry's TTL has elapsed.
+func (s *resolverSuite) TestLookupUidInvalidatesNegativeEntryAfterTTL(c *C) {
+	s.setTTL(c, "10ms")
+
+	const username = "resolver-test-user-not-yet-created"
+	created := false
+	s.mockFindUidNoGetentFallback(c, func(name string) (uint64, error) {
+		c.Assert(name, Equals, username)
+		if !created {
+			return 0, user.UnknownUserError(name)
+		}
+		return 1234, nil
+	})
+
+	r := NewUserGroupResolver()
+
+	_, err := r.LookupUid(username)
+	c.Assert(IsUnknownUser(err), Equals, true)
+
+	// the user is "created" but the cached negative entry is still fresh,
+	// so the stale answer is served without consulting findUidNoGetentFallback
+	created = true
+	_, err = r.LookupUid(username)
+	c.Assert(IsUnknownUser(err), Equals, true)
+
+	time.Sleep(20 * time.Millisecond)
+
+	id, err := r.LookupUid(username)
+	c.Assert(err, IsNil)
+	c.Check(id, Equals, uint64(1234))
+}
+
+// TestLookupGroupnameInvalidatesNegativeEntryAfterTTL mirrors the uid case
+// for the reverse gid -> name direction.
+func (s *resolverSuite) TestLookupGroupnameInvalidatesNegativeEntryAfterTTL(c *C) {
+	s.setTTL(c, "10ms")
+
+	const gid = uint64(4321)
+	created := false
+	s.mockFindGroupnameNoGetentFallback(c, func(id uint64) (string, error) {
+		c.Assert(id, Equals, gid)
+		if !created {
+			return "", user.UnknownGroupIdError(fmt.Sprint(id))
+		}
+		return "newgroup", nil
+	})
+
+	r := NewUserGroupResolver()
+
+	_, err := r.LookupGroupname(gid)
+	c.Assert(IsUnknownGroup(err), Equals, true)
+
+	created = true
+	_, err = r.LookupGroupname(gid)
+	c.Assert(IsUnknownGroup(err), Equals, true)
+
+	time.Sleep(20 * time.Millisecond)
+
+	name, err := r.LookupGroupname(gid)
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "newgroup")
+}
+
+// TestLookupUidServesFreshPositiveEntryFromCache locks down that a
+// successful lookup is cached too, avoiding a second call into
+// findUidNoGetentFallback for the TTL's duration.
+func (s *resolverSuite) TestLookupUidServesFreshPositiveEntryFromCache(c *C) {
+	s.setTTL(c, "1m")
+
+	const username = "resolver-test-user-cached"
+	calls := 0
+	s.mockFindUidNoGetentFallback(c, func(name string) (uint64, error) {
+		calls++
+		return 42, nil
+	})
+
+	r := NewUserGroupResolver()
+
+	_, err := r.LookupUid(username)
+	c.Assert(err, IsNil)
+	_, err = r.LookupUid(username)
+	c.Assert(err, IsNil)
+	c.Check(calls, Equals, 1)
+}
@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+// IDRange is a contiguous range of sub-uids or sub-gids, as allocated to a
+// user or group in /etc/subuid or /etc/subgid.
+type IDRange struct {
+	Start uint32
+	Count uint32
+}
+
+// SubUIDRanges returns the sub-uid ranges allocated to the given user name
+// (or numeric uid) in /etc/subuid.
+func SubUIDRanges(username string) ([]IDRange, error) {
+	return subIDRanges(subUIDPath, username)
+}
+
+// SubGIDRanges returns the sub-gid ranges allocated to the given user name
+// (or numeric uid) in /etc/subgid.
+func SubGIDRanges(name string) ([]IDRange, error) {
+	return subIDRanges(subGIDPath, name)
+}
+
+// AllocateSubIDRange atomically appends a new, non-overlapping range of
+// "count" sub-uids for the given user name to /etc/subuid, taking a flock
+// on /etc/subuid.lock first so that concurrent allocations do not race,
+// following the same locking convention as shadow-utils' newuidmap and
+// newgidmap.
+func AllocateSubIDRange(name string, count uint32) (IDRange, error) {
+	return allocateSubIDRange(subUIDPath, name, count)
+}
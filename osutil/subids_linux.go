@@ -0,0 +1,199 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// subUIDPath and subGIDPath are variables (rather than constants) so that
+// tests can point them at a temporary file.
+var (
+	subUIDPath = "/etc/subuid"
+	subGIDPath = "/etc/subgid"
+)
+
+// defaultSubIDStart mirrors the SUB_UID_MIN/SUB_GID_MIN default
+// shadow-utils ships in /etc/login.defs, used when the file has no prior
+// entries to derive the next allocation from.
+const defaultSubIDStart = 100000
+
+// subIDEntry is a single parsed "name:start:count" line of /etc/subuid or
+// /etc/subgid.
+type subIDEntry struct {
+	Name string
+	IDRange
+}
+
+// subIDRanges returns the ranges allocated to name in the subuid/subgid
+// file at path.
+func subIDRanges(path, name string) ([]IDRange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := parseSubIDEntries(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []IDRange
+	for _, entry := range entries {
+		if subIDNameMatches(entry.Name, name) {
+			ranges = append(ranges, entry.IDRange)
+		}
+	}
+
+	return ranges, nil
+}
+
+// parseSubIDEntries parses the "name:start:count" lines of a subuid/subgid
+// file, skipping blank and "#"-commented lines, and aggregating every line
+// found (a user may have more than one range).
+func parseSubIDEntries(r io.Reader) ([]subIDEntry, error) {
+	var entries []subIDEntry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed subid entry: %q", line)
+		}
+		start, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed subid entry: %q", line)
+		}
+		count, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed subid entry: %q", line)
+		}
+
+		entries = append(entries, subIDEntry{
+			Name:    fields[0],
+			IDRange: IDRange{Start: uint32(start), Count: uint32(count)},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// subIDLookupUid resolves a user name to a uid; overridden in tests so
+// subIDNameMatches can be exercised without relying on real system users.
+var subIDLookupUid = FindUid
+
+// subIDNameMatches reports whether the name field of a subuid/subgid entry
+// and the queried name refer to the same user, matching shadow-utils
+// semantics of accepting either a textual user name or a numeric uid on
+// either side of the comparison (the file may record a uid for a user
+// looked up by name, or vice versa).
+func subIDNameMatches(field, name string) bool {
+	if field == name {
+		return true
+	}
+
+	fieldID, fieldIsID := parseUintField(field)
+	nameID, nameIsID := parseUintField(name)
+
+	switch {
+	case fieldIsID && nameIsID:
+		return fieldID == nameID
+	case fieldIsID && !nameIsID:
+		actual, err := subIDLookupUid(name)
+		return err == nil && actual == fieldID
+	case !fieldIsID && nameIsID:
+		actual, err := subIDLookupUid(field)
+		return err == nil && actual == nameID
+	default:
+		return false
+	}
+}
+
+func parseUintField(s string) (uint64, bool) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	return id, err == nil
+}
+
+// allocateSubIDRange appends a new, non-overlapping range of "count" ids
+// for "name" to the subuid/subgid file at path, taking a flock on
+// "<path>.lock" to serialize concurrent allocations.
+func allocateSubIDRange(path, name string, count uint32) (IDRange, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return IDRange{}, fmt.Errorf("cannot open %s.lock: %v", path, err)
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return IDRange{}, fmt.Errorf("cannot lock %s.lock: %v", path, err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return IDRange{}, fmt.Errorf("cannot open %s: %v", path, err)
+	}
+	defer f.Close()
+
+	entries, err := parseSubIDEntries(f)
+	if err != nil {
+		return IDRange{}, err
+	}
+
+	next := uint64(defaultSubIDStart)
+	for _, entry := range entries {
+		if entry.Name == name {
+			return IDRange{}, fmt.Errorf("cannot allocate subid range: %q already has a range", name)
+		}
+		end := uint64(entry.Start) + uint64(entry.Count)
+		if end > next {
+			next = end
+		}
+	}
+	if next+uint64(count) > 1<<32 {
+		return IDRange{}, fmt.Errorf("cannot allocate subid range: no space left in %s", path)
+	}
+
+	allocated := IDRange{Start: uint32(next), Count: count}
+	line := fmt.Sprintf("%s:%d:%d\n", name, allocated.Start, allocated.Count)
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return IDRange{}, fmt.Errorf("cannot seek %s: %v", path, err)
+	}
+	if _, err := f.WriteString(line); err != nil {
+		return IDRange{}, fmt.Errorf("cannot write to %s: %v", path, err)
+	}
+
+	return allocated, nil
+}
@@ -0,0 +1,165 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type subidsSuite struct{}
+
+var _ = Suite(&subidsSuite{})
+
+func (s *subidsSuite) mockSubIDLookupUid(c *C, f func(string) (uint64, error)) {
+	old := subIDLookupUid
+	subIDLookupUid = f
+	c.AddCleanup(func() { subIDLookupUid = old })
+}
+
+func (s *subidsSuite) TestParseSubIDEntriesValid(c *C) {
+	data := `
+# comment line, ignored
+alice:100000:65536
+
+bob:165536:65536
+alice:231072:65536
+`
+	entries, err := parseSubIDEntries(strings.NewReader(data))
+	c.Assert(err, IsNil)
+	want := []subIDEntry{
+		{Name: "alice", IDRange: IDRange{Start: 100000, Count: 65536}},
+		{Name: "bob", IDRange: IDRange{Start: 165536, Count: 65536}},
+		{Name: "alice", IDRange: IDRange{Start: 231072, Count: 65536}},
+	}
+	c.Check(entries, DeepEquals, want)
+}
+
+func (s *subidsSuite) TestParseSubIDEntriesMalformed(c *C) {
+	malformed := []string{
+		"alice:100000",
+		"alice:100000:65536:extra",
+		"alice:notanumber:65536",
+		"alice:100000:notanumber",
+	}
+	for _, line := range malformed {
+		_, err := parseSubIDEntries(strings.NewReader(line))
+		c.Check(err, NotNil, Commentf("line %q", line))
+	}
+}
+
+func (s *subidsSuite) TestSubIDRangesAggregatesDuplicateUser(c *C) {
+	path := filepath.Join(c.MkDir(), "subuid")
+	data := "alice:100000:65536\nbob:165536:65536\nalice:231072:65536\n"
+	c.Assert(os.WriteFile(path, []byte(data), 0644), IsNil)
+
+	ranges, err := subIDRanges(path, "alice")
+	c.Assert(err, IsNil)
+	c.Check(ranges, DeepEquals, []IDRange{{Start: 100000, Count: 65536}, {Start: 231072, Count: 65536}})
+}
+
+func (s *subidsSuite) TestSubIDNameMatches(c *C) {
+	s.mockSubIDLookupUid(c, func(name string) (uint64, error) {
+		if name == "alice" {
+			return 1000, nil
+		}
+		return 0, fmt.Errorf("unknown user %q", name)
+	})
+
+	cases := []struct {
+		field, name string
+		want        bool
+	}{
+		{"alice", "alice", true},
+		{"1000", "1000", true},
+		{"1000", "alice", true}, // field is a uid, query is the matching name
+		{"alice", "1000", true}, // field is a name, query is the matching uid
+		{"alice", "bob", false},
+		{"1000", "bob", false},
+		{"2000", "alice", false},
+	}
+	for _, tc := range cases {
+		c.Check(subIDNameMatches(tc.field, tc.name), Equals, tc.want, Commentf("field=%q name=%q", tc.field, tc.name))
+	}
+}
+
+func (s *subidsSuite) TestAllocateSubIDRangeAppendsNonOverlapping(c *C) {
+	path := filepath.Join(c.MkDir(), "subuid")
+	c.Assert(os.WriteFile(path, []byte("alice:100000:65536\n"), 0644), IsNil)
+
+	got, err := allocateSubIDRange(path, "bob", 65536)
+	c.Assert(err, IsNil)
+	want := IDRange{Start: 165536, Count: 65536}
+	c.Check(got, Equals, want)
+
+	ranges, err := subIDRanges(path, "bob")
+	c.Assert(err, IsNil)
+	c.Check(ranges, DeepEquals, []IDRange{want})
+}
+
+func (s *subidsSuite) TestAllocateSubIDRangeRejectsDuplicateName(c *C) {
+	path := filepath.Join(c.MkDir(), "subuid")
+	c.Assert(os.WriteFile(path, []byte("alice:100000:65536\n"), 0644), IsNil)
+
+	_, err := allocateSubIDRange(path, "alice", 65536)
+	c.Check(err, NotNil)
+}
+
+func (s *subidsSuite) TestAllocateSubIDRangeConcurrent(c *C) {
+	path := filepath.Join(c.MkDir(), "subuid")
+	c.Assert(os.WriteFile(path, []byte(""), 0644), IsNil)
+
+	const n = 20
+	var wg sync.WaitGroup
+	ranges := make([]IDRange, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ranges[i], errs[i] = allocateSubIDRange(path, fmt.Sprintf("user%d", i), 65536)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[uint32]string)
+	for i, err := range errs {
+		c.Assert(err, IsNil, Commentf("allocation %d", i))
+		r := ranges[i]
+		for other, name := range seen {
+			overlaps := r.Start < other+r.Count && other < r.Start+r.Count
+			c.Assert(overlaps, Equals, false, Commentf("range %+v for user%d overlaps range allocated to %s", r, i, name))
+		}
+		seen[r.Start] = fmt.Sprintf("user%d", i)
+	}
+
+	f, err := os.Open(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	allEntries, err := parseSubIDEntries(f)
+	c.Assert(err, IsNil)
+	c.Check(allEntries, HasLen, n)
+}
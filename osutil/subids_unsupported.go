@@ -0,0 +1,38 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+//go:build !linux
+// +build !linux
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package osutil
+
+import "fmt"
+
+var (
+	subUIDPath = "/etc/subuid"
+	subGIDPath = "/etc/subgid"
+)
+
+func subIDRanges(path, name string) ([]IDRange, error) {
+	return nil, fmt.Errorf("sub-uid/sub-gid ranges are only supported on Linux")
+}
+
+func allocateSubIDRange(path, name string, count uint32) (IDRange, error) {
+	return IDRange{}, fmt.Errorf("sub-uid/sub-gid ranges are only supported on Linux")
+}